@@ -0,0 +1,209 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// defArenaChunkSize is the size of the first backing chunk an
+// ArenaAllocator requests from its parent; later chunks grow
+// geometrically from there.
+const defArenaChunkSize = 64 * 1024
+
+// arenaAlignment is the alignment every sub-allocation carved out of a
+// chunk is rounded up to, matching the 64-byte alignment Arrow buffers
+// require for SIMD access.
+const arenaAlignment = 64
+
+// arenaChunk is one backing region carved off of the parent allocator;
+// off is the number of bytes already handed out from buf.
+type arenaChunk struct {
+	buf []byte
+	off int
+}
+
+// ArenaAllocator serves many small allocations out of a handful of large
+// backing chunks obtained from a parent Allocator, and frees them all at
+// once via Reset or Release instead of tracking each allocation
+// individually. This fits workloads like parsing one Parquet row group
+// or building one RecordBatch, where thousands of transient buffers
+// share a single lifetime: bulk-freeing amortizes the per-allocation
+// overhead far better than routing every buffer through the parent
+// allocator's own Allocate/Free.
+//
+// Every sub-allocation is rounded up to an arenaAlignment-byte boundary
+// within its chunk, matching the alignment Arrow buffers require for
+// SIMD access.
+//
+// Individual calls to Free are no-ops; call Reset to reuse the arena's
+// chunks for a new batch of allocations, or Release to return the
+// chunks to the parent allocator entirely. An ArenaAllocator is safe for
+// concurrent use.
+type ArenaAllocator struct {
+	parent    Allocator
+	chunkSize int
+
+	// owner is set when this arena is wrapped by a *CheckedAllocator
+	// (i.e. it is that allocator's mem), so Reset and Release can make
+	// it forget the leak-tracking entries for the arena's chunks as part
+	// of the bulk reclaim, instead of requiring the caller to remember
+	// to call CheckedAllocator.ForgetArena separately.
+	owner *CheckedAllocator
+
+	mu     sync.Mutex
+	chunks []arenaChunk
+}
+
+// NewArenaAllocator returns an ArenaAllocator that requests its backing
+// chunks from parent, growing each new chunk geometrically starting
+// from defArenaChunkSize.
+func NewArenaAllocator(parent Allocator) *ArenaAllocator {
+	return &ArenaAllocator{parent: parent, chunkSize: defArenaChunkSize}
+}
+
+// NewArenaAllocatorScope returns an ArenaAllocator backed by parent along
+// with a cleanup function that releases it, for the common case of
+// scoping an arena to one batch with a single defer. Calling the
+// returned function is equivalent to calling Release directly; if a
+// *CheckedAllocator is then constructed around the returned arena,
+// Release already forgets the arena's leak-tracking entries on its own
+// (see the owner field).
+func NewArenaAllocatorScope(parent Allocator) (*ArenaAllocator, func()) {
+	arena := NewArenaAllocator(parent)
+	return arena, arena.Release
+}
+
+func (a *ArenaAllocator) Allocate(size int) []byte {
+	if size == 0 {
+		return a.parent.Allocate(0)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n := len(a.chunks); n > 0 {
+		c := &a.chunks[n-1]
+		off := (c.off + arenaAlignment - 1) &^ (arenaAlignment - 1)
+		if len(c.buf)-off >= size {
+			out := c.buf[off : off+size : off+size]
+			c.off = off + size
+			return out
+		}
+	}
+
+	chunkSize := a.chunkSize
+	if size > chunkSize {
+		chunkSize = size
+	}
+	a.chunkSize *= 2
+
+	buf := a.parent.Allocate(chunkSize)
+	a.chunks = append(a.chunks, arenaChunk{buf: buf, off: size})
+	return buf[:size:size]
+}
+
+// Reallocate always behaves as a fresh Allocate plus copy: the arena
+// does not track per-allocation metadata, so it cannot tell whether b is
+// the most recent allocation from its current chunk and grow it in
+// place.
+func (a *ArenaAllocator) Reallocate(size int, b []byte) []byte {
+	out := a.Allocate(size)
+	copy(out, b)
+	return out
+}
+
+// Free is a no-op: individual allocations are reclaimed in bulk by Reset
+// or Release, not one at a time.
+func (a *ArenaAllocator) Free(b []byte) {}
+
+// Reset rewinds every chunk back to empty so its backing storage can be
+// reused for a new batch of allocations, without returning any memory to
+// the parent allocator. Allocate only carves from the most recently added
+// chunk, so after a Reset only that chunk's storage is handed out again;
+// earlier chunks stay held, unused, until Release. If this arena is
+// wrapped by a *CheckedAllocator,
+// that allocator forgets the leak-tracking entries for the allocations
+// being rewound, matching the fact that their addresses are about to be
+// handed out again.
+func (a *ArenaAllocator) Reset() {
+	a.mu.Lock()
+	ranges := a.chunkRangesLocked()
+	for i := range a.chunks {
+		a.chunks[i].off = 0
+	}
+	a.mu.Unlock()
+
+	if a.owner != nil {
+		a.owner.forgetRanges(ranges)
+	}
+}
+
+// Release returns every chunk to the parent allocator and drops them
+// from the arena; the arena may be used again afterwards, starting from
+// a fresh defArenaChunkSize chunk. If this arena is wrapped by a
+// *CheckedAllocator, that allocator forgets the leak-tracking entries
+// for the arena's allocations as part of the release, so the bulk free
+// of thousands of sub-allocations that never went through an individual
+// Free is not reported as a leak by the wrapper's next AssertSize.
+func (a *ArenaAllocator) Release() {
+	a.mu.Lock()
+	ranges := a.chunkRangesLocked()
+	for _, c := range a.chunks {
+		a.parent.Free(c.buf)
+	}
+	a.chunks = nil
+	a.chunkSize = defArenaChunkSize
+	a.mu.Unlock()
+
+	if a.owner != nil {
+		a.owner.forgetRanges(ranges)
+	}
+}
+
+// addrRange is a half-open [start, end) byte address range.
+type addrRange struct {
+	start, end uintptr
+}
+
+// chunkRangesLocked returns the address range covered by each of the
+// arena's backing chunks; callers must hold a.mu.
+func (a *ArenaAllocator) chunkRangesLocked() []addrRange {
+	ranges := make([]addrRange, 0, len(a.chunks))
+	for _, c := range a.chunks {
+		if len(c.buf) == 0 {
+			continue
+		}
+		start := uintptr(unsafe.Pointer(&c.buf[0]))
+		ranges = append(ranges, addrRange{start: start, end: start + uintptr(len(c.buf))})
+	}
+	return ranges
+}
+
+// chunkRanges returns the address range covered by each of the arena's
+// backing chunks, used by CheckedAllocator.ForgetArena to recognize
+// which tracked allocations were served out of this arena.
+func (a *ArenaAllocator) chunkRanges() []addrRange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.chunkRangesLocked()
+}
+
+var (
+	_ Allocator = (*ArenaAllocator)(nil)
+)
@@ -0,0 +1,77 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArenaAllocator_SubAllocationsAreAligned(t *testing.T) {
+	a := NewArenaAllocator(NewGoAllocator())
+
+	// Odd sizes force c.off to land on a non-aligned byte if it is not
+	// rounded up before the next allocation is carved out.
+	for i := 0; i < 8; i++ {
+		b := a.Allocate(1)
+		assert.Zero(t, uintptr(unsafe.Pointer(&b[0]))%arenaAlignment)
+	}
+}
+
+func TestArenaAllocator_ResetReusesChunks(t *testing.T) {
+	a := NewArenaAllocator(NewGoAllocator())
+
+	b1 := a.Allocate(64)
+	a.Reset()
+	b2 := a.Allocate(64)
+
+	assert.Equal(t, &b1[0], &b2[0])
+}
+
+func TestArenaAllocator_WrappedByCheckedAllocator_ReleaseForgetsLeaks(t *testing.T) {
+	arena := NewArenaAllocator(NewGoAllocator())
+	checked := NewCheckedAllocator(arena)
+
+	for i := 0; i < 16; i++ {
+		checked.Allocate(64)
+	}
+
+	arena.Release()
+
+	checked.AssertSize(t, 0)
+}
+
+func TestArenaAllocator_WrappedByCheckedAllocator_ResetForgetsLeaks(t *testing.T) {
+	arena := NewArenaAllocator(NewGoAllocator())
+	checked := NewCheckedAllocator(arena)
+
+	checked.Allocate(64)
+	arena.Reset()
+
+	checked.AssertSize(t, 0)
+}
+
+func TestNewArenaAllocatorScope(t *testing.T) {
+	arena, release := NewArenaAllocatorScope(NewGoAllocator())
+
+	b := arena.Allocate(64)
+	assert.Len(t, b, 64)
+
+	assert.NotPanics(t, release)
+}
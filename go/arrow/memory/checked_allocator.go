@@ -32,27 +32,65 @@ type CheckedAllocator struct {
 	sz  int64
 
 	allocs sync.Map
+
+	// sampleRate is the number of bytes, on average, between sampled
+	// allocations (see SetSampleRate). 0 (the default) disables
+	// sampling: every allocation is tracked, matching the historical
+	// behavior of CheckedAllocator.
+	sampleRate int64
+	residuals  [sampleShards]int64 // goroutine id bucketed by shard, see shouldSample
+
+	// poison is non-nil when this allocator was constructed with
+	// NewCheckedAllocatorWithPoison; it drives use-after-free and
+	// double-free detection in checked_allocator_poison.go.
+	poison *poisonState
 }
 
 func NewCheckedAllocator(mem Allocator) *CheckedAllocator {
-	return &CheckedAllocator{mem: mem}
+	a := &CheckedAllocator{mem: mem, sampleRate: int64(defaultSampleRate)}
+	if arena, ok := mem.(*ArenaAllocator); ok {
+		// Let arena forget its own leak-tracking entries on Reset/Release
+		// instead of requiring callers to remember to call ForgetArena.
+		arena.owner = a
+	}
+	return a
 }
 
 func (a *CheckedAllocator) CurrentAlloc() int { return int(atomic.LoadInt64(&a.sz)) }
 
+// SetSampleRate sets the average number of bytes between sampled
+// allocations, mirroring runtime.MemProfileRate. A rate of 0 disables
+// sampling so that every allocation is tracked exactly, which is the
+// default and matches CheckedAllocator's original behavior.
+func (a *CheckedAllocator) SetSampleRate(rate int) {
+	atomic.StoreInt64(&a.sampleRate, int64(rate))
+}
+
 func (a *CheckedAllocator) Allocate(size int) []byte {
+	return a.allocate(size, nil)
+}
+
+// allocate is the shared implementation behind Allocate and
+// AllocateWithContext; labels is nil unless the caller came through
+// AllocateWithContext with an active label set.
+func (a *CheckedAllocator) allocate(size int, labels map[string]string) []byte {
 	atomic.AddInt64(&a.sz, int64(size))
 	out := a.mem.Allocate(size)
 	if size == 0 {
 		return out
 	}
 
+	sampled, scaledSz := a.shouldSample(size)
+	if !sampled {
+		return out
+	}
+
 	ptr := uintptr(unsafe.Pointer(&out[0]))
 	pcs := make([]uintptr, maxRetainedFrames)
 	runtime.Callers(allocFrames, pcs)
 	callersFrames := runtime.CallersFrames(pcs)
 	if pc, _, l, ok := runtime.Caller(allocFrames); ok {
-		a.allocs.Store(ptr, &dalloc{pc: pc, line: l, sz: size, callersFrames: callersFrames})
+		a.allocs.Store(ptr, &dalloc{pc: pc, line: l, sz: scaledSz, pcs: pcs, callersFrames: callersFrames, labels: labels})
 	}
 	return out
 }
@@ -68,11 +106,17 @@ func (a *CheckedAllocator) Reallocate(size int, b []byte) []byte {
 
 	newptr := uintptr(unsafe.Pointer(&out[0]))
 	a.allocs.Delete(oldptr)
+
+	sampled, scaledSz := a.shouldSample(size)
+	if !sampled {
+		return out
+	}
+
 	pcs := make([]uintptr, maxRetainedFrames)
 	runtime.Callers(reallocFrames, pcs)
 	callersFrames := runtime.CallersFrames(pcs)
 	if pc, _, l, ok := runtime.Caller(reallocFrames); ok {
-		a.allocs.Store(newptr, &dalloc{pc: pc, line: l, sz: size, callersFrames: callersFrames})
+		a.allocs.Store(newptr, &dalloc{pc: pc, line: l, sz: scaledSz, pcs: pcs, callersFrames: callersFrames})
 	}
 
 	return out
@@ -80,14 +124,26 @@ func (a *CheckedAllocator) Reallocate(size int, b []byte) []byte {
 
 func (a *CheckedAllocator) Free(b []byte) {
 	atomic.AddInt64(&a.sz, int64(len(b)*-1))
-	defer a.mem.Free(b)
 
 	if len(b) == 0 {
+		a.mem.Free(b)
 		return
 	}
 
 	ptr := uintptr(unsafe.Pointer(&b[0]))
 	a.allocs.Delete(ptr)
+
+	if a.poison != nil {
+		// onFree owns handing b back to a.mem: with poisoning enabled,
+		// freed buffers are held in a quarantine rather than returned
+		// right away, so a double-free or use-after-free can be told
+		// apart from the backing allocator legitimately reusing the
+		// address.
+		a.poison.onFree(a.mem, ptr, b)
+		return
+	}
+
+	a.mem.Free(b)
 }
 
 // typically the allocations are happening in memory.Buffer, not by consumers calling
@@ -98,13 +154,16 @@ const (
 	defAllocFrames       = 4
 	defReallocFrames     = 3
 	defMaxRetainedFrames = 0
+	defSampleRate        = 0
 )
 
 // Use the environment variables ARROW_CHECKED_ALLOC_FRAMES and ARROW_CHECKED_REALLOC_FRAMES
 // to control how many frames it skips when storing the caller for allocations/reallocs
 // when using this to find memory leaks. Use ARROW_CHECKED_MAX_RETAINED_FRAMES to control how
-// many frames are retained for printing the stack trace of a leak.
-var allocFrames, reallocFrames, maxRetainedFrames int = defAllocFrames, defReallocFrames, defMaxRetainedFrames
+// many frames are retained for printing the stack trace of a leak. Use
+// ARROW_CHECKED_SAMPLE_RATE to set the default sampling rate (see SetSampleRate)
+// for every CheckedAllocator created after the variable is read.
+var allocFrames, reallocFrames, maxRetainedFrames, defaultSampleRate int = defAllocFrames, defReallocFrames, defMaxRetainedFrames, defSampleRate
 
 func init() {
 	if val, ok := os.LookupEnv("ARROW_CHECKED_ALLOC_FRAMES"); ok {
@@ -124,13 +183,21 @@ func init() {
 			maxRetainedFrames = f
 		}
 	}
+
+	if val, ok := os.LookupEnv("ARROW_CHECKED_SAMPLE_RATE"); ok {
+		if f, err := strconv.Atoi(val); err == nil {
+			defaultSampleRate = f
+		}
+	}
 }
 
 type dalloc struct {
 	pc            uintptr
 	line          int
 	sz            int
+	pcs           []uintptr
 	callersFrames *runtime.Frames
+	labels        map[string]string
 }
 
 type TestingT interface {
@@ -157,7 +224,11 @@ func (a *CheckedAllocator) AssertSize(t TestingT, sz int) {
 				break
 			}
 		}
-		t.Errorf("LEAK of %d bytes FROM %s line %d\n%v", info.sz, f.Name(), info.line, callersMsg.String())
+		if len(info.labels) > 0 {
+			t.Errorf("LEAK of %d bytes FROM %s line %d labels=%v\n%v", info.sz, f.Name(), info.line, info.labels, callersMsg.String())
+		} else {
+			t.Errorf("LEAK of %d bytes FROM %s line %d\n%v", info.sz, f.Name(), info.line, callersMsg.String())
+		}
 		return true
 	})
 
@@ -167,6 +238,68 @@ func (a *CheckedAllocator) AssertSize(t TestingT, sz int) {
 	}
 }
 
+// AssertSizeWithTolerance behaves like AssertSize, but is meant for use
+// with a non-zero sample rate (see SetSampleRate): since sampled allocations have their sizes
+// scaled up to estimate the true outstanding footprint, the leak table
+// is an estimate rather than an exact accounting. tolerance bounds how
+// far a.sz (which is always tracked exactly, regardless of sampling) may
+// drift from sz before it is reported as a mismatch.
+func (a *CheckedAllocator) AssertSizeWithTolerance(t TestingT, sz, tolerance int) {
+	a.allocs.Range(func(_, value interface{}) bool {
+		info := value.(*dalloc)
+		f := runtime.FuncForPC(info.pc)
+		t.Errorf("LEAK of ~%d bytes (sampled) FROM %s line %d", info.sz, f.Name(), info.line)
+		return true
+	})
+
+	if diff := int(atomic.LoadInt64(&a.sz)) - sz; diff > tolerance || diff < -tolerance {
+		t.Helper()
+		t.Errorf("invalid memory size exp=%d, got=%d (tolerance=%d)", sz, a.sz, tolerance)
+	}
+}
+
+// ForgetArena removes the leak-tracking entries for every allocation
+// that was served out of arena's chunks (i.e. whose address falls
+// within one of the arena's backing buffers), and reverses their
+// contribution to a's outstanding-byte count. Use this when a wraps an
+// ArenaAllocator and tracks its individual sub-allocations: since a
+// bulk arena.Reset or arena.Release never calls a.Free for each of
+// those sub-allocations, without this they would otherwise be reported
+// as leaked (or as a size mismatch) by a's next AssertSize.
+//
+// When a wraps arena (i.e. a was constructed with arena as its mem),
+// NewCheckedAllocator registers a with arena so that arena's own Reset
+// and Release do the same forgetting automatically; call this directly
+// only for an arena that a does not itself wrap, such as one nested
+// further down the allocation tree.
+func (a *CheckedAllocator) ForgetArena(arena *ArenaAllocator) {
+	a.forgetRanges(arena.chunkRanges())
+}
+
+// forgetRanges removes the leak-tracking entries for every tracked
+// allocation whose address falls within one of ranges, and reverses
+// their contribution to a's outstanding-byte count.
+func (a *CheckedAllocator) forgetRanges(ranges []addrRange) {
+	if len(ranges) == 0 {
+		return
+	}
+
+	var freed int64
+	a.allocs.Range(func(key, value interface{}) bool {
+		ptr := key.(uintptr)
+		for _, r := range ranges {
+			if ptr >= r.start && ptr < r.end {
+				info := value.(*dalloc)
+				freed += int64(info.sz)
+				a.allocs.Delete(ptr)
+				break
+			}
+		}
+		return true
+	})
+	atomic.AddInt64(&a.sz, -freed)
+}
+
 type CheckedAllocatorScope struct {
 	alloc *CheckedAllocator
 	sz    int
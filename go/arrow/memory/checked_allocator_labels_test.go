@@ -0,0 +1,58 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushLabel_DoesNotMutateParentContext(t *testing.T) {
+	base := context.Background()
+	withRequest := PushLabel(base, "request", "abc")
+	withBoth := PushLabel(withRequest, "query", "xyz")
+
+	assert.Equal(t, map[string]string{"request": "abc"}, labelsFromContext(withRequest))
+	assert.Equal(t, map[string]string{"request": "abc", "query": "xyz"}, labelsFromContext(withBoth))
+	assert.Nil(t, labelsFromContext(base))
+}
+
+func TestWithLabels_AppliesAllPairs(t *testing.T) {
+	var got map[string]string
+	WithLabels(context.Background(), func(ctx context.Context) {
+		got = labelsFromContext(ctx)
+	}, "request", "abc", "query", "xyz")
+
+	assert.Equal(t, map[string]string{"request": "abc", "query": "xyz"}, got)
+}
+
+func TestCheckedAllocator_AllocateWithContext_AttributesLeak(t *testing.T) {
+	ft := &fakeT{}
+	a := NewCheckedAllocator(NewGoAllocator())
+
+	ctx := PushLabel(context.Background(), "query", "q1")
+	a.AllocateWithContext(ctx, 32)
+
+	a.AssertSize(ft, 32)
+
+	if assert.Len(t, ft.errs, 1) {
+		assert.Contains(t, ft.errs[0], "query")
+		assert.Contains(t, ft.errs[0], "q1")
+	}
+}
@@ -0,0 +1,173 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"runtime"
+	"sync"
+)
+
+// poisonBytes is the sentinel pattern written over a buffer's contents
+// when it is freed.
+var poisonBytes = [4]byte{0xDE, 0xAD, 0xBE, 0xEF}
+
+const defQuarantineCapacity = 256
+
+// CheckedAllocatorPoisonOptions configures NewCheckedAllocatorWithPoison.
+type CheckedAllocatorPoisonOptions struct {
+	// T receives immediate reports of poison violations (use-after-free,
+	// double-free) as they are detected, as opposed to AssertSize's
+	// after-the-fact leak report.
+	T TestingT
+	// QuarantineCapacity bounds how many freed allocations are held out
+	// of circulation (poisoned, but not yet handed back to the backing
+	// allocator) at once. A larger quarantine catches use-after-free
+	// bugs over a longer window at the cost of retaining more freed
+	// memory. Defaults to defQuarantineCapacity if zero.
+	QuarantineCapacity int
+}
+
+// NewCheckedAllocatorWithPoison returns a CheckedAllocator that, in
+// addition to its normal leak tracking, detects two classes of
+// buffer-lifetime bugs:
+//
+//   - use-after-free: on Free, the buffer is overwritten with a
+//     recognizable sentinel pattern and held in a bounded quarantine
+//     instead of being returned to mem right away. Only once it is
+//     evicted from the quarantine (because newer frees pushed it out)
+//     is it checked for corruption and hand it back to mem -- if the
+//     sentinel was overwritten in the meantime, something still held and
+//     wrote through a stale reference.
+//   - double-free: freeing an address that is still sitting in the
+//     quarantine (i.e. already freed and not yet returned to mem) is
+//     reported immediately, with both free call stacks.
+//
+// Holding freed buffers out of circulation for a while, rather than
+// poisoning and immediately handing them back to mem, is what makes this
+// safe to use with an address-reusing allocator: mem cannot legitimately
+// reissue an address that CheckedAllocator is still quarantining, so a
+// report always corresponds to a real bug rather than ordinary address
+// reuse.
+func NewCheckedAllocatorWithPoison(mem Allocator, opts CheckedAllocatorPoisonOptions) *CheckedAllocator {
+	a := NewCheckedAllocator(mem)
+
+	capacity := opts.QuarantineCapacity
+	if capacity <= 0 {
+		capacity = defQuarantineCapacity
+	}
+	a.poison = &poisonState{t: opts.T, cap: capacity}
+	return a
+}
+
+// quarantined is a freed buffer being held out of circulation: poisoned
+// and not yet handed back to the backing allocator.
+type quarantined struct {
+	ptr   uintptr
+	buf   []byte
+	stack []uintptr
+}
+
+// poisonState holds the extra bookkeeping NewCheckedAllocatorWithPoison
+// needs: the reporter to call on a violation, and the bounded quarantine
+// FIFO of freed-but-not-yet-returned buffers.
+type poisonState struct {
+	t   TestingT
+	cap int
+
+	mu         sync.Mutex
+	quarantine []quarantined
+}
+
+// onFree checks whether ptr is already sitting in the quarantine (a
+// double-free), and if not, poisons b and adds it to the quarantine. If
+// that pushes the quarantine over capacity, the oldest entry is evicted:
+// checked for corruption, then actually returned to mem.
+func (p *poisonState) onFree(mem Allocator, ptr uintptr, b []byte) {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	pcs = pcs[:n]
+
+	p.mu.Lock()
+
+	for _, q := range p.quarantine {
+		if q.ptr == ptr {
+			p.mu.Unlock()
+			p.t.Errorf("DOUBLE-FREE detected at %#x (%d bytes): first freed from:\n%s\nsecond freed from:\n%s",
+				ptr, len(b), formatStack(q.stack), formatStack(pcs))
+			return
+		}
+	}
+
+	poison(b)
+	p.quarantine = append(p.quarantine, quarantined{ptr: ptr, buf: b, stack: pcs})
+
+	var evicted *quarantined
+	if len(p.quarantine) > p.cap {
+		e := p.quarantine[0]
+		p.quarantine = p.quarantine[1:]
+		evicted = &e
+	}
+
+	p.mu.Unlock()
+
+	if evicted != nil {
+		p.checkEviction(*evicted)
+		mem.Free(evicted.buf)
+	}
+}
+
+// checkEviction reports a use-after-free if q's buffer no longer carries
+// the poison pattern it was written with at free time, then lets it be
+// returned to the backing allocator.
+func (p *poisonState) checkEviction(q quarantined) {
+	if isPoisoned(q.buf) {
+		return
+	}
+	p.t.Errorf("USE-AFTER-FREE detected: %d bytes at %#x were written to after being freed from:\n%s",
+		len(q.buf), q.ptr, formatStack(q.stack))
+}
+
+func poison(b []byte) {
+	for i := range b {
+		b[i] = poisonBytes[i%len(poisonBytes)]
+	}
+}
+
+func isPoisoned(b []byte) bool {
+	for i := range b {
+		if b[i] != poisonBytes[i%len(poisonBytes)] {
+			return false
+		}
+	}
+	return true
+}
+
+func formatStack(pcs []uintptr) string {
+	frames := runtime.CallersFrames(pcs)
+	var out string
+	for {
+		frame, more := frames.Next()
+		if frame.Line == 0 {
+			break
+		}
+		out += "\t" + frame.Function + "\n"
+		if !more {
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeT collects Errorf calls instead of failing the test directly, so
+// tests can assert on how many (and which) violations were reported.
+type fakeT struct {
+	errs []string
+}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.errs = append(f.errs, fmt.Sprintf(format, args...))
+}
+func (f *fakeT) Helper() {}
+
+func TestCheckedAllocatorWithPoison_NormalReuseIsNotFlagged(t *testing.T) {
+	// PooledAllocator freely hands back the exact same freed buffer on a
+	// later Allocate of the same size class, without clearing the
+	// poison CheckedAllocator wrote into it -- that is completely normal
+	// address reuse, not a use-after-free, and must not be reported as
+	// one. Cycle well past QuarantineCapacity so buffers actually get
+	// evicted and checked.
+	mem := NewPooledAllocator(NewGoAllocator(), 0)
+
+	ft := &fakeT{}
+	a := NewCheckedAllocatorWithPoison(mem, CheckedAllocatorPoisonOptions{T: ft, QuarantineCapacity: 4})
+
+	for i := 0; i < 64; i++ {
+		a.Free(a.Allocate(64))
+	}
+
+	assert.Empty(t, ft.errs)
+}
+
+func TestCheckedAllocatorWithPoison_DoubleFreeDetected(t *testing.T) {
+	ft := &fakeT{}
+	a := NewCheckedAllocatorWithPoison(NewGoAllocator(), CheckedAllocatorPoisonOptions{T: ft})
+
+	b := a.Allocate(64)
+	a.Free(b)
+	a.Free(b)
+
+	if assert.Len(t, ft.errs, 1) {
+		assert.Contains(t, ft.errs[0], "DOUBLE-FREE")
+	}
+}
+
+func TestCheckedAllocatorWithPoison_UseAfterFreeDetected(t *testing.T) {
+	ft := &fakeT{}
+	a := NewCheckedAllocatorWithPoison(NewGoAllocator(), CheckedAllocatorPoisonOptions{T: ft, QuarantineCapacity: 1})
+
+	b := a.Allocate(64)
+	a.Free(b)
+
+	// Simulate a stale reference writing through freed memory while it
+	// is still sitting in the quarantine.
+	b[0] = 0xff
+
+	// Push it out of the quarantine (capacity 1) so it gets checked.
+	a.Free(a.Allocate(64))
+
+	if assert.Len(t, ft.errs, 1) {
+		assert.Contains(t, ft.errs[0], "USE-AFTER-FREE")
+	}
+}
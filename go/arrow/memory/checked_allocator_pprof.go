@@ -0,0 +1,151 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// WriteHeapProfile writes the outstanding allocations tracked by a as a
+// gzipped pprof protobuf profile to w, with two sample types,
+// inuse_objects and inuse_space, so the result can be inspected with
+// `go tool pprof` (flame graphs, top-N callers, etc) instead of scraping
+// the text emitted by AssertSize. Allocations are grouped by their
+// retained call stack and label set: every dalloc sharing the same
+// sequence of PCs and the same labels contributes to a single sample,
+// with counts and bytes summed across the group.
+func (a *CheckedAllocator) WriteHeapProfile(w io.Writer) error {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		},
+		PeriodType: &profile.ValueType{Type: "space", Unit: "bytes"},
+		Period:     1,
+	}
+
+	locs := make(map[uintptr]*profile.Location)
+	fns := make(map[uintptr]*profile.Function)
+	samples := make(map[string]*profile.Sample)
+
+	a.allocs.Range(func(_, value interface{}) bool {
+		info := value.(*dalloc)
+
+		var (
+			key       string
+			stackLocs []*profile.Location
+		)
+		for _, pc := range info.pcs {
+			if pc == 0 {
+				continue
+			}
+			loc, ok := locs[pc]
+			if !ok {
+				fn, ok := fns[pc]
+				if !ok {
+					rf := runtime.FuncForPC(pc)
+					name := "unknown"
+					if rf != nil {
+						name = rf.Name()
+					}
+					fn = &profile.Function{ID: uint64(len(fns) + 1), Name: name, SystemName: name}
+					fns[pc] = fn
+					p.Function = append(p.Function, fn)
+				}
+				loc = &profile.Location{
+					ID:   uint64(len(locs) + 1),
+					Line: []profile.Line{{Function: fn}},
+				}
+				locs[pc] = loc
+				p.Location = append(p.Location, loc)
+			}
+			key += fmt.Sprintf("%d/", loc.ID)
+			stackLocs = append(stackLocs, loc)
+		}
+
+		labelKeys := make([]string, 0, len(info.labels))
+		for k := range info.labels {
+			labelKeys = append(labelKeys, k)
+		}
+		sort.Strings(labelKeys)
+		for _, k := range labelKeys {
+			key += fmt.Sprintf("|%s=%s", k, info.labels[k])
+		}
+
+		s, ok := samples[key]
+		if !ok {
+			labels := make(map[string][]string, len(info.labels))
+			for k, v := range info.labels {
+				labels[k] = []string{v}
+			}
+			s = &profile.Sample{Value: []int64{0, 0}, Location: stackLocs, Label: labels}
+			samples[key] = s
+			p.Sample = append(p.Sample, s)
+		}
+		s.Value[0]++
+		s.Value[1] += int64(info.sz)
+		return true
+	})
+
+	return p.Write(w)
+}
+
+// AssertSizeWithProfile behaves like AssertSize, but on failure (a leak
+// or a size mismatch) it also writes a pprof heap profile of the
+// outstanding allocations to a temp file and prints its path, so leaks
+// can be opened with `go tool pprof` instead of read off of Errorf
+// output.
+func (a *CheckedAllocator) AssertSizeWithProfile(t TestingT, sz int) {
+	hasLeaks := false
+	a.allocs.Range(func(_, value interface{}) bool {
+		info := value.(*dalloc)
+		f := runtime.FuncForPC(info.pc)
+		t.Errorf("LEAK of %d bytes FROM %s line %d", info.sz, f.Name(), info.line)
+		hasLeaks = true
+		return true
+	})
+
+	mismatch := int(a.CurrentAlloc()) != sz
+	if mismatch {
+		t.Helper()
+		t.Errorf("invalid memory size exp=%d, got=%d", sz, a.CurrentAlloc())
+	}
+
+	if !hasLeaks && !mismatch {
+		return
+	}
+
+	f, err := os.CreateTemp("", "arrow-heap-*.pprof")
+	if err != nil {
+		t.Errorf("failed to create heap profile temp file: %s", err)
+		return
+	}
+	defer f.Close()
+
+	if err := a.WriteHeapProfile(f); err != nil {
+		t.Errorf("failed to write heap profile: %s", err)
+		return
+	}
+
+	t.Errorf("wrote heap profile of outstanding allocations to %s (open with `go tool pprof %s`)", f.Name(), f.Name())
+}
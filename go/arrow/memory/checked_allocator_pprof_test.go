@@ -0,0 +1,72 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckedAllocator_WriteHeapProfile(t *testing.T) {
+	a := NewCheckedAllocator(NewGoAllocator())
+
+	a.Allocate(16)
+	a.Allocate(16)
+	ctx := PushLabel(context.Background(), "query", "q1")
+	a.AllocateWithContext(ctx, 32)
+
+	var buf bytes.Buffer
+	if !assert.NoError(t, a.WriteHeapProfile(&buf)) {
+		return
+	}
+
+	p, err := profile.Parse(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var totalObjects, totalSpace int64
+	var sawLabel bool
+	for _, s := range p.Sample {
+		totalObjects += s.Value[0]
+		totalSpace += s.Value[1]
+		if vs, ok := s.Label["query"]; ok {
+			assert.Equal(t, []string{"q1"}, vs)
+			sawLabel = true
+		}
+	}
+
+	assert.EqualValues(t, 3, totalObjects)
+	assert.EqualValues(t, 64, totalSpace)
+	assert.True(t, sawLabel, "expected one sample group carrying the query label")
+}
+
+func TestCheckedAllocator_AssertSizeWithProfile_WritesProfileOnLeak(t *testing.T) {
+	ft := &fakeT{}
+	a := NewCheckedAllocator(NewGoAllocator())
+
+	a.Allocate(16)
+	a.AssertSizeWithProfile(ft, 0)
+
+	if assert.NotEmpty(t, ft.errs) {
+		assert.Contains(t, ft.errs[len(ft.errs)-1], "go tool pprof")
+	}
+}
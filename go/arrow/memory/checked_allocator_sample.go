@@ -0,0 +1,113 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// sampleShards bounds the number of residual byte counters a
+// CheckedAllocator keeps for sampling, regardless of how many distinct
+// goroutines ever allocate through it. A goroutine is bucketed into a
+// shard by its id (see residualFor); a power of two keeps that bucketing
+// a cheap mask instead of a division.
+const sampleShards = 256
+
+// shouldSample decides, for an allocation of size bytes, whether
+// CheckedAllocator should pay the cost of capturing a stack trace for
+// it. It mirrors the approach used by runtime.MemProfileRate: a
+// residual byte counter, shared by every goroutine bucketed into the
+// same shard, is decremented by size on every call, and once it runs
+// out a sample is taken and the residual is reset to a new
+// geometrically-distributed interval averaging a.sampleRate bytes. When
+// sampling is disabled (sampleRate <= 0) every allocation is sampled,
+// preserving CheckedAllocator's original behavior.
+//
+// The returned size is the value that should be recorded for the
+// sample: unsampled bytes are still added to a.sz exactly by the
+// caller, but a *sampled* dalloc's recorded size is scaled up to
+// max(size, sampleRate) bytes so that summing the leak table estimates
+// the true outstanding footprint (a coarse approximation of the
+// size/(1-exp(-size/rate)) correction runtime/pprof's heap profile
+// applies for Poisson sampling).
+func (a *CheckedAllocator) shouldSample(size int) (sampled bool, recordSz int) {
+	rate := atomic.LoadInt64(&a.sampleRate)
+	if rate <= 0 {
+		return true, size
+	}
+
+	residual := a.residualFor(goid())
+	if atomic.AddInt64(residual, -int64(size)) > 0 {
+		return false, 0
+	}
+
+	atomic.StoreInt64(residual, nextSampleInterval(rate))
+	if size >= int(rate) {
+		return true, size
+	}
+	return true, int(rate)
+}
+
+// residualFor returns the residual byte counter for the shard gid is
+// bucketed into. Unlike one entry per goroutine ever seen, this keeps
+// a.residuals a fixed size for the lifetime of the process, which
+// matters for long-running services with many short-lived goroutines.
+// Several goroutines sharing a shard will perturb each other's sampling
+// interval, which is an acceptable trade for a bounded table.
+func (a *CheckedAllocator) residualFor(gid int64) *int64 {
+	return &a.residuals[uint64(gid)%sampleShards]
+}
+
+// nextSampleInterval draws the number of bytes until the next sample
+// from an exponential distribution with mean rate, the same model
+// runtime.MemProfileRate uses, so that sampling a long-running
+// allocator's byte stream behaves like sampling Go's own heap profile.
+func nextSampleInterval(rate int64) int64 {
+	if rate <= 0 {
+		return 0
+	}
+	interval := -rand.ExpFloat64() * float64(rate)
+	return int64(-interval) + 1
+}
+
+// goid returns the calling goroutine's numeric id, used only to key the
+// per-goroutine sampling residual. It is not on any hot path unless
+// sampling is enabled, and even then only pays for a small runtime.Stack
+// call rather than the full runtime.Callers capture it is meant to
+// avoid.
+func goid() int64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	b := buf[:n]
+
+	const prefix = "goroutine "
+	if !bytes.HasPrefix(b, []byte(prefix)) {
+		return 0
+	}
+	b = b[len(prefix):]
+
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
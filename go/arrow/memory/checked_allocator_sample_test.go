@@ -0,0 +1,80 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckedAllocator_SamplingAcrossManyGoroutines(t *testing.T) {
+	// Many goroutines share the fixed sampleShards residual counters
+	// (unlike the old one-entry-per-goroutine map), so several of them
+	// hammer the same *int64 concurrently via shouldSample. Run under
+	// -race to catch any unsynchronized access, and assert that tracking
+	// still comes out exactly right once every allocation is freed --
+	// sharing a shard must not corrupt another goroutine's accounting.
+	ft := &fakeT{}
+	a := NewCheckedAllocator(NewGoAllocator())
+	a.SetSampleRate(256)
+
+	const goroutines = 200
+	const allocsPerGoroutine = 50
+
+	bufs := make([][][]byte, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		g := g
+		bufs[g] = make([][]byte, allocsPerGoroutine)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range bufs[g] {
+				bufs[g][i] = a.Allocate(64)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, gb := range bufs {
+		for _, b := range gb {
+			a.Free(b)
+		}
+	}
+
+	a.AssertSize(ft, 0)
+	assert.Empty(t, ft.errs)
+}
+
+func TestCheckedAllocator_SampleRateZeroSamplesEverything(t *testing.T) {
+	ft := &fakeT{}
+	a := NewCheckedAllocator(NewGoAllocator())
+	a.SetSampleRate(0)
+
+	// With sampling disabled the allocation is tracked, so it is reported
+	// as a leak until it is freed.
+	b := a.Allocate(16)
+	a.AssertSize(ft, 16)
+	assert.Len(t, ft.errs, 1)
+
+	ft.errs = nil
+	a.Free(b)
+	a.AssertSize(ft, 0)
+	assert.Empty(t, ft.errs)
+}
@@ -0,0 +1,199 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// pageSize is the bucket granularity used once a requested size grows
+// past largestPooledClass; buffers that big are rounded up to a multiple
+// of pageSize instead of the next power-of-two, since power-of-two
+// rounding would waste too much memory at that scale.
+const pageSize = 4096
+
+// largestPooledClass is the largest size (in bytes) that is rounded up to
+// a power-of-two size class. Requests larger than this are rounded up to
+// a multiple of pageSize instead.
+const largestPooledClass = 64 * 1024
+
+// minPooledClass is the smallest size class PooledAllocator keeps a
+// freelist for. Allocations smaller than this are rounded up to it so
+// that the number of size classes (and therefore freelists) stays small.
+const minPooledClass = 64
+
+// pooledClass is a single size class's freelist along with the number of
+// bytes of that class currently sitting in it.
+type pooledClass struct {
+	pool   sync.Pool
+	cached int64
+}
+
+// PooledAllocator wraps an Allocator and caches freed buffers in
+// per-size-class freelists, so that repeated Allocate/Free cycles of
+// similarly sized buffers (the common case for Arrow validity/data
+// buffers via Buffer.Resize/Reserve) avoid round-tripping through the
+// backing allocator. It is modeled on the size-classed pooling design
+// used by modernc.org/memory: each requested size is rounded up to a
+// class (a power-of-two below largestPooledClass, a page-multiple above
+// it), and each class keeps its own sync.Pool of spare buffers.
+//
+// Sizes at or below largestPooledClass use a fixed, small number of
+// power-of-two classes held in a plain slice. Larger sizes are bucketed
+// by page count instead, which is unbounded in principle (a pathological
+// caller could request close to the full address space), so that tier
+// is kept in a sync.Map rather than sized up front.
+//
+// A PooledAllocator is safe for concurrent use.
+type PooledAllocator struct {
+	mem Allocator
+
+	sizeClasses []pooledClass // index sizeClassFor(n) for n <= largestPooledClass
+	pageClasses sync.Map      // page count (int) -> *pooledClass, for n > largestPooledClass
+
+	highWater int64 // per-class cap on cached bytes, 0 means unlimited
+
+	hits, misses, bytesCached int64
+}
+
+// NewPooledAllocator returns a PooledAllocator that pools freed buffers
+// before falling back to mem. highWater, if non-zero, bounds how many
+// bytes of freed buffers are retained per size class; buffers freed
+// beyond that cap are released to mem instead of being cached.
+func NewPooledAllocator(mem Allocator, highWater int64) *PooledAllocator {
+	return &PooledAllocator{
+		mem:         mem,
+		sizeClasses: make([]pooledClass, sizeClassFor(largestPooledClass)+1),
+		highWater:   highWater,
+	}
+}
+
+// sizeClassFor returns the power-of-two size class index for sizes at or
+// below largestPooledClass, rounding size up to minPooledClass first.
+func sizeClassFor(size int) int {
+	if size < minPooledClass {
+		size = minPooledClass
+	}
+	return bits.Len(uint(size-1)) - bits.Len(uint(minPooledClass-1))
+}
+
+// classFor returns the pooledClass a request of size bytes is served
+// from, along with the actual byte size that class allocates. Sizes
+// above largestPooledClass are keyed by page count in pageClasses,
+// created lazily on first use instead of pre-sized, since the number of
+// distinct page counts a workload can produce is unbounded in principle.
+func (a *PooledAllocator) classFor(size int) (*pooledClass, int) {
+	if size <= largestPooledClass {
+		idx := sizeClassFor(size)
+		return &a.sizeClasses[idx], minPooledClass << uint(idx)
+	}
+
+	pages := (size + pageSize - 1) / pageSize
+	v, _ := a.pageClasses.LoadOrStore(pages, &pooledClass{})
+	return v.(*pooledClass), pages * pageSize
+}
+
+func (a *PooledAllocator) Allocate(size int) []byte {
+	if size == 0 {
+		return a.mem.Allocate(0)
+	}
+
+	class, classSize := a.classFor(size)
+	if v := class.pool.Get(); v != nil {
+		atomic.AddInt64(&a.hits, 1)
+		atomic.AddInt64(&class.cached, -int64(classSize))
+		atomic.AddInt64(&a.bytesCached, -int64(classSize))
+		return (v.([]byte))[:size]
+	}
+
+	atomic.AddInt64(&a.misses, 1)
+	return a.mem.Allocate(classSize)[:size]
+}
+
+func (a *PooledAllocator) Reallocate(size int, b []byte) []byte {
+	if size <= cap(b) {
+		oldClass, _ := a.classFor(cap(b))
+		newClass, _ := a.classFor(size)
+		if newClass == oldClass {
+			return b[:size]
+		}
+	}
+
+	out := a.Allocate(size)
+	copy(out, b)
+	a.Free(b)
+	return out
+}
+
+func (a *PooledAllocator) Free(b []byte) {
+	if len(b) == 0 && cap(b) == 0 {
+		return
+	}
+
+	class, classSize := a.classFor(cap(b))
+	if a.highWater > 0 && atomic.LoadInt64(&class.cached)+int64(classSize) > a.highWater {
+		a.mem.Free(b[:cap(b)])
+		return
+	}
+
+	atomic.AddInt64(&class.cached, int64(classSize))
+	atomic.AddInt64(&a.bytesCached, int64(classSize))
+	class.pool.Put(b[:cap(b):cap(b)])
+}
+
+// Trim drains every per-class freelist, releasing the cached buffers
+// back to the backing allocator. Use it to give memory back to the OS
+// between bursts of allocation activity.
+func (a *PooledAllocator) Trim() {
+	drain := func(class *pooledClass) {
+		for {
+			v := class.pool.Get()
+			if v == nil {
+				break
+			}
+			b := v.([]byte)
+			atomic.AddInt64(&class.cached, -int64(cap(b)))
+			atomic.AddInt64(&a.bytesCached, -int64(cap(b)))
+			a.mem.Free(b)
+		}
+	}
+
+	for i := range a.sizeClasses {
+		drain(&a.sizeClasses[i])
+	}
+	a.pageClasses.Range(func(_, v interface{}) bool {
+		drain(v.(*pooledClass))
+		return true
+	})
+}
+
+// Hits returns the number of Allocate calls satisfied from a freelist.
+func (a *PooledAllocator) Hits() int64 { return atomic.LoadInt64(&a.hits) }
+
+// Misses returns the number of Allocate calls that fell through to the
+// backing allocator.
+func (a *PooledAllocator) Misses() int64 { return atomic.LoadInt64(&a.misses) }
+
+// BytesCached returns the number of bytes currently sitting in freelists
+// across all size classes.
+func (a *PooledAllocator) BytesCached() int64 { return atomic.LoadInt64(&a.bytesCached) }
+
+var (
+	_ Allocator = (*PooledAllocator)(nil)
+)
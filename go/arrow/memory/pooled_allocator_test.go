@@ -0,0 +1,72 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPooledAllocator(t *testing.T) {
+	// NewPooledAllocator used to size a freelist slice off of
+	// pageClassFor(1<<62), which tried to allocate ~1.1e15 elements and
+	// panicked immediately. It must simply succeed.
+	assert.NotPanics(t, func() {
+		NewPooledAllocator(NewGoAllocator(), 0)
+	})
+}
+
+func TestPooledAllocator_ReusesFreedBuffer(t *testing.T) {
+	a := NewPooledAllocator(NewGoAllocator(), 0)
+
+	b1 := a.Allocate(128)
+	a.Free(b1)
+	assert.EqualValues(t, 1, a.Misses())
+
+	b2 := a.Allocate(128)
+	assert.EqualValues(t, 1, a.Hits())
+	assert.EqualValues(t, 1, a.Misses())
+	assert.Equal(t, 128, len(b2))
+}
+
+func TestPooledAllocator_PageClass(t *testing.T) {
+	// Sizes above largestPooledClass exercise the page-multiple tier,
+	// which is keyed by page count in a sync.Map rather than a flat
+	// slice sized off of an arbitrary upper bound.
+	a := NewPooledAllocator(NewGoAllocator(), 0)
+
+	sz := largestPooledClass + 1
+	b1 := a.Allocate(sz)
+	a.Free(b1)
+	assert.EqualValues(t, 1, a.Misses())
+
+	b2 := a.Allocate(sz)
+	assert.EqualValues(t, 1, a.Hits())
+	assert.Equal(t, sz, len(b2))
+}
+
+func TestPooledAllocator_Trim(t *testing.T) {
+	a := NewPooledAllocator(NewGoAllocator(), 0)
+
+	a.Free(a.Allocate(128))
+	a.Free(a.Allocate(largestPooledClass + 1))
+	assert.Greater(t, a.BytesCached(), int64(0))
+
+	a.Trim()
+	assert.EqualValues(t, 0, a.BytesCached())
+}